@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// isAdmin checks the X-Admin-Token header against ADMIN_TOKEN.
+// Admin endpoints are disabled when no token is configured.
+func isAdmin(r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		return false
+	}
+	got := r.Header.Get(adminTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) == 1
+}
+
+// handleCacheClear is DELETE /api/cache/clear[?endpoint=/nft/...].
+// It removes cached responses and reports how many files were deleted.
+func (p *moralisProxy) handleCacheClear(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger{id: requestID(r)}
+	w.Header().Set(requestIDHeader, logger.id)
+
+	if r.Method != http.MethodDelete {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r, p.adminToken) {
+		writeJSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefix := r.URL.Query().Get("endpoint")
+	deleted, err := clearCache(p.cacheDir, prefix)
+	if err != nil {
+		logger.Errorf("Cache clear failed: %v", err)
+		writeJSONError(w, "failed to clear cache", http.StatusInternalServerError)
+		return
+	}
+	if prefix != "" {
+		logger.Infof("Cleared %d cache entries matching %s", deleted, prefix)
+	} else {
+		logger.Infof("Cleared %d cache entries", deleted)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+	})
+}
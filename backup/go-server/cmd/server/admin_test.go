@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cacheFiles returns the number of .json files in dir.
+func cacheFiles(t *testing.T, dir string) int {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func clearRequest(p *moralisProxy, token, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodDelete, "/api/cache/clear"+query, nil)
+	if token != "" {
+		r.Header.Set(adminTokenHeader, token)
+	}
+	w := httptest.NewRecorder()
+	p.handleCacheClear(w, r)
+	return w
+}
+
+func newAdminTestProxy(t *testing.T) *moralisProxy {
+	t.Helper()
+	p := newTestProxy(t, okUpstream)
+	p.adminToken = "secret"
+	for _, endpoint := range []string{"/nft/" + testContract + "/owners", "/nft/" + testContract + "/transfers", "/nft/0x2222222222222222222222222222222222222222"} {
+		if err := writeCacheEntry(p.cachePath(proxyRequest{Endpoint: endpoint}), proxyRequest{Endpoint: endpoint}, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestCacheClearAll(t *testing.T) {
+	p := newAdminTestProxy(t)
+
+	w := clearRequest(p, "secret", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var got map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got["deleted"] != 3 {
+		t.Errorf("body = %s, want {\"deleted\":3}", w.Body.String())
+	}
+	if n := cacheFiles(t, p.cacheDir); n != 0 {
+		t.Errorf("%d cache files left, want 0", n)
+	}
+}
+
+func TestCacheClearPrefix(t *testing.T) {
+	p := newAdminTestProxy(t)
+	// Old bare-format files have no endpoint and are kept by a prefix clear
+	os.WriteFile(filepath.Join(p.cacheDir, "legacy.json"), []byte(`{"result":[]}`), 0644)
+
+	w := clearRequest(p, "secret", "?endpoint=/nft/"+testContract)
+	var got map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got["deleted"] != 2 {
+		t.Errorf("body = %s, want {\"deleted\":2}", w.Body.String())
+	}
+	if n := cacheFiles(t, p.cacheDir); n != 2 {
+		t.Errorf("%d cache files left, want 2", n)
+	}
+}
+
+func TestCacheClearUnauthorized(t *testing.T) {
+	p := newAdminTestProxy(t)
+
+	for _, token := range []string{"", "wrong"} {
+		if w := clearRequest(p, token, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want 401", token, w.Code)
+		}
+	}
+	// No ADMIN_TOKEN configured disables the endpoint entirely
+	p.adminToken = ""
+	if w := clearRequest(p, "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("no admin token: status = %d, want 401", w.Code)
+	}
+	if n := cacheFiles(t, p.cacheDir); n != 3 {
+		t.Errorf("%d cache files left, want all 3", n)
+	}
+}
+
+func TestCacheClearMethod(t *testing.T) {
+	p := newAdminTestProxy(t)
+	r := httptest.NewRequest(http.MethodPost, "/api/cache/clear", nil)
+	r.Header.Set(adminTokenHeader, "secret")
+	w := httptest.NewRecorder()
+	p.handleCacheClear(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", w.Code)
+	}
+}
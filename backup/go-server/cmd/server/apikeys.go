@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errKeysRateLimited is returned when every Moralis key is over its per-key rate.
+var errKeysRateLimited = errors.New("all moralis api keys are rate limited")
+
+// maxKeyStrikes is how many 401s in a row a key may get on calls that
+// another key then completed before it is dropped from the rotation.
+const maxKeyStrikes = 3
+
+// apiKeyPool rotates requests round-robin across one or more Moralis API keys.
+// Keys that keep getting 401s where other keys succeed are dropped from the
+// rotation, but the last key is always kept.
+type apiKeyPool struct {
+	mu      sync.Mutex
+	keys    []string
+	strikes map[string]int // key -> consecutive strikes, see strike
+	next    int
+	limiter *keyedRateLimiter // per-key rate limit; nil means unlimited
+}
+
+// loadAPIKey returns the Moralis API key, read from the file at
+// MORALIS_API_KEY_FILE when set (mounted secrets), otherwise from MORALIS_API_KEY.
+// TrimSpace removes any accidental newlines or spaces from the secret.
+func loadAPIKey() string {
+	if path := os.Getenv("MORALIS_API_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			infof("Reading Moralis API Key from file: %s", path)
+			return strings.TrimSpace(string(data))
+		}
+		warnf("Warning: Failed to read MORALIS_API_KEY_FILE: %v. Falling back to MORALIS_API_KEY.", err)
+	}
+	return strings.TrimSpace(os.Getenv("MORALIS_API_KEY"))
+}
+
+// loadAPIKeyPool reads the comma-separated MORALIS_API_KEYS, falling back to
+// the single key from loadAPIKey. MORALIS_KEY_RPS optionally limits each key.
+func loadAPIKeyPool() *apiKeyPool {
+	pool := &apiKeyPool{}
+	for _, k := range strings.Split(os.Getenv("MORALIS_API_KEYS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			pool.keys = append(pool.keys, k)
+		}
+	}
+	if len(pool.keys) == 0 {
+		if k := loadAPIKey(); k != "" {
+			pool.keys = []string{k}
+		}
+	}
+
+	if raw := os.Getenv("MORALIS_KEY_RPS"); raw != "" {
+		rps, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rps <= 0 {
+			warnf("Warning: Invalid MORALIS_KEY_RPS %q, keys are not rate limited", raw)
+		} else {
+			pool.limiter = newKeyedRateLimiter(rps)
+			infof("Moralis per-key rate limit: %.2f req/s", rps)
+		}
+	}
+	return pool
+}
+
+// size returns the number of keys still in rotation.
+func (p *apiKeyPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// pick returns the next key in rotation that is under its rate limit.
+// With no keys configured it returns "" so the request fails upstream as before.
+func (p *apiKeyPool) pick() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.keys) == 0 {
+		return "", nil
+	}
+	for i := 0; i < len(p.keys); i++ {
+		key := p.keys[p.next%len(p.keys)]
+		p.next = (p.next + 1) % len(p.keys)
+		if p.limiter == nil {
+			return key, nil
+		}
+		if ok, _ := p.limiter.allow(key); ok {
+			return key, nil
+		}
+	}
+	return "", errKeysRateLimited
+}
+
+// succeeded resets key's strikes after Moralis accepted it.
+func (p *apiKeyPool) succeeded(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.strikes, key)
+}
+
+// strike records a 401 for key on a call that another key then completed,
+// i.e. the endpoint works and the key is the problem. After maxKeyStrikes in
+// a row the key is dropped, unless it's the last one. The key itself is never logged.
+func (p *apiKeyPool) strike(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.strikes == nil {
+		p.strikes = make(map[string]int)
+	}
+	p.strikes[key]++
+	if p.strikes[key] < maxKeyStrikes || len(p.keys) < 2 {
+		return
+	}
+	for i, k := range p.keys {
+		if k != key {
+			continue
+		}
+		p.keys = append(p.keys[:i], p.keys[i+1:]...)
+		delete(p.strikes, key)
+		p.next %= len(p.keys)
+		warnf("Warning: Moralis rejected API key #%d (length %d) %d times where another key succeeded, removed from rotation. %d key(s) left.", i+1, len(key), maxKeyStrikes, len(p.keys))
+		return
+	}
+}
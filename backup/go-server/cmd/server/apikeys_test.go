@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadAPIKeyFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moralis-key")
+	os.WriteFile(path, []byte("  file-key\n"), 0600)
+	t.Setenv("MORALIS_API_KEY_FILE", path)
+	t.Setenv("MORALIS_API_KEY", "env-key")
+
+	if got := loadAPIKey(); got != "file-key" {
+		t.Errorf("loadAPIKey() = %q, want the trimmed file contents", got)
+	}
+}
+
+func TestLoadAPIKeyFallsBackToEnv(t *testing.T) {
+	t.Setenv("MORALIS_API_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("MORALIS_API_KEY", " env-key\n")
+
+	if got := loadAPIKey(); got != "env-key" {
+		t.Errorf("loadAPIKey() = %q, want the trimmed env value", got)
+	}
+}
+
+// keyRecordingProxy returns a proxy using keys whose mock upstream records
+// the X-API-Key of each call and answers 401 when unauthorized(key, path) is true.
+func keyRecordingProxy(t *testing.T, keys []string, unauthorized func(key, path string) bool) (*moralisProxy, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var seen []string
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		mu.Lock()
+		seen = append(seen, key)
+		mu.Unlock()
+		if unauthorized(key, r.URL.Path) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Unauthorized"}`))
+			return
+		}
+		okUpstream(w, r)
+	})
+	p.keys = &apiKeyPool{keys: keys}
+	return p, &seen
+}
+
+func proxyPage(p *moralisProxy, endpoint string, page int) int {
+	return postProxy(p, fmt.Sprintf(`{"endpoint":"%s","params":{"cursor":"%d"}}`, endpoint, page)).Code
+}
+
+func TestAPIKeysAlternate(t *testing.T) {
+	p, seen := keyRecordingProxy(t, []string{"key-a", "key-b"}, func(string, string) bool { return false })
+
+	for i := 0; i < 4; i++ {
+		proxyPage(p, "/nft/"+testContract, i)
+	}
+	if want := []string{"key-a", "key-b", "key-a", "key-b"}; !slices.Equal(*seen, want) {
+		t.Errorf("keys used = %q, want %q", *seen, want)
+	}
+}
+
+func TestAPIKey401RotatesToNextKey(t *testing.T) {
+	p, seen := keyRecordingProxy(t, []string{"bad", "good"}, func(key, _ string) bool { return key == "bad" })
+
+	for i := 0; i < maxKeyStrikes; i++ {
+		if code := proxyPage(p, "/nft/"+testContract, i); code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200 via the next key", i, code)
+		}
+	}
+	if want := []string{"bad", "good", "bad", "good", "bad", "good"}; !slices.Equal(*seen, want) {
+		t.Errorf("keys used = %q, want %q", *seen, want)
+	}
+
+	// Dropped after maxKeyStrikes, so later calls go straight to the good key
+	if n := p.keys.size(); n != 1 {
+		t.Fatalf("pool size = %d, want 1", n)
+	}
+	*seen = nil
+	proxyPage(p, "/nft/"+testContract, maxKeyStrikes)
+	if want := []string{"good"}; !slices.Equal(*seen, want) {
+		t.Errorf("keys used = %q, want %q", *seen, want)
+	}
+}
+
+func TestAPIKeyEndpoint401KeepsKeys(t *testing.T) {
+	// Moralis answers 401 for this route whatever the key
+	tokenRoute := "/nft/" + testContract + "/42"
+	p, _ := keyRecordingProxy(t, []string{"key-a", "key-b"}, func(_, path string) bool { return path == tokenRoute })
+
+	for i := 0; i < 2*maxKeyStrikes; i++ {
+		if code := proxyPage(p, tokenRoute, i); code != http.StatusUnauthorized {
+			t.Errorf("request %d: status = %d, want the upstream 401", i, code)
+		}
+	}
+	if n := p.keys.size(); n != 2 {
+		t.Errorf("pool size = %d, want both keys kept", n)
+	}
+}
+
+func TestAPIKeyLastKeyNeverDropped(t *testing.T) {
+	p, _ := keyRecordingProxy(t, []string{"only"}, func(string, string) bool { return true })
+	for i := 0; i < 2*maxKeyStrikes; i++ {
+		proxyPage(p, "/nft/"+testContract, i)
+	}
+
+	// Strikes alone can't empty the pool either
+	for i := 0; i < 2*maxKeyStrikes; i++ {
+		p.keys.strike("only")
+	}
+	if n := p.keys.size(); n != 1 {
+		t.Fatalf("pool size = %d, want 1", n)
+	}
+	w := httptest.NewRecorder()
+	handleHealthz(p.keys)(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if !strings.Contains(w.Body.String(), `"moralis_key_loaded":true`) {
+		t.Errorf("healthz = %s, want the key still loaded", w.Body.String())
+	}
+}
+
+func TestAPIKeyPoolFromEnv(t *testing.T) {
+	t.Setenv("MORALIS_API_KEYS", " key-a, ,key-b ")
+	t.Setenv("MORALIS_KEY_RPS", "")
+	if got := loadAPIKeyPool(); !slices.Equal(got.keys, []string{"key-a", "key-b"}) {
+		t.Errorf("keys = %q, want [key-a key-b]", got.keys)
+	}
+
+	t.Setenv("MORALIS_API_KEYS", "")
+	t.Setenv("MORALIS_API_KEY_FILE", "")
+	t.Setenv("MORALIS_API_KEY", "single")
+	if got := loadAPIKeyPool(); !slices.Equal(got.keys, []string{"single"}) {
+		t.Errorf("keys = %q, want the MORALIS_API_KEY fallback", got.keys)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	maxBatchSize     = 50
+	batchConcurrency = 4
+)
+
+// batchResult is one entry of a batch response, in the same order as the request.
+type batchResult struct {
+	Endpoint string          `json:"endpoint"`
+	Status   int             `json:"status"`
+	Cached   bool            `json:"cached"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// handleBatch resolves an array of proxy requests with bounded concurrency.
+// Each item goes through the disk cache on its own, items that miss it count
+// against the client's rate limit, and a failing item doesn't fail the whole batch.
+func (p *moralisProxy) handleBatch(w http.ResponseWriter, r *http.Request, raw json.RawMessage, logger requestLogger) {
+	var reqs []proxyRequest
+	if err := json.Unmarshal(raw, &reqs); err != nil {
+		writeJSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchSize {
+		writeJSONError(w, fmt.Sprintf("batch must contain between 1 and %d requests", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	logger.Debugf("Batch request with %d items", len(reqs))
+
+	client := clientIP(r)
+	results := make([]batchResult, len(reqs))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, req := range reqs {
+		results[i].Endpoint = req.Endpoint
+
+		// Only forward allowlisted Moralis endpoints
+		if !isAllowedEndpoint(req.Endpoint) {
+			logger.Warnf("Rejected endpoint: %s", req.Endpoint)
+			results[i].Status = http.StatusForbidden
+			results[i].Error = "endpoint not allowed"
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, req proxyRequest) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// Items that miss the cache cost one token each, so a batch
+			// can't multiply the client's Moralis quota
+			res, err := p.resolve(r.Context(), req, client, logger)
+			if errors.Is(err, errClientRateLimited) {
+				logger.Debugf("Rate limited batch item: %s", req.Endpoint)
+			} else if err != nil {
+				logger.Errorf("Proxy Error: Moralis request failed: %v", err)
+			}
+			if err != nil {
+				results[i].Status, results[i].Error = upstreamFailure(err)
+				return
+			}
+			results[i].Status = res.status
+			results[i].Cached = res.cached
+			if json.Valid(res.body) {
+				results[i].Body = res.body
+			} else {
+				results[i].Error = "invalid json from upstream"
+			}
+		}(i, req)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// postBatch sends body to POST /api/proxy and decodes the batch response.
+func postBatch(t *testing.T, p *moralisProxy, body string) []batchResult {
+	t.Helper()
+	w := postProxy(p, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("invalid batch response %s: %v", w.Body.String(), err)
+	}
+	return results
+}
+
+func TestBatchCachedPerItem(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+	owners := `{"endpoint":"/nft/` + testContract + `/owners"}`
+	transfers := `{"endpoint":"/nft/` + testContract + `/transfers"}`
+
+	// Warm the cache for one of the two items
+	if w := postProxy(p, owners); w.Code != http.StatusOK {
+		t.Fatalf("warm-up status = %d", w.Code)
+	}
+
+	results := postBatch(t, p, "["+owners+","+transfers+"]")
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for i, want := range []bool{true, false} {
+		if results[i].Status != http.StatusOK || results[i].Cached != want {
+			t.Errorf("item %d: status %d cached %v, want 200 cached %v", i, results[i].Status, results[i].Cached, want)
+		}
+		if string(results[i].Body) != `{"result":[]}` {
+			t.Errorf("item %d: body = %s", i, results[i].Body)
+		}
+	}
+
+	results = postBatch(t, p, "["+owners+","+transfers+"]")
+	for i := range results {
+		if !results[i].Cached {
+			t.Errorf("second batch item %d: cached = false, want true", i)
+		}
+	}
+}
+
+func TestBatchRateLimitedPerItem(t *testing.T) {
+	var calls atomic.Int32
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		okUpstream(w, r)
+	})
+	p.limiter = newKeyedRateLimiter(1) // burst of 2
+
+	item := func(id string) string { return `{"endpoint":"/nft/` + testContract + `/` + id + `"}` }
+	results := postBatch(t, p, "["+item("1")+","+item("2")+","+item("3")+"]")
+
+	// Items run concurrently, so which one is limited isn't fixed
+	statuses := map[int]int{}
+	for _, res := range results {
+		statuses[res.Status]++
+	}
+	if statuses[http.StatusOK] != 2 || statuses[http.StatusTooManyRequests] != 1 {
+		t.Errorf("statuses = %v, want two 200s and one 429", statuses)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d, want 2", n)
+	}
+}
+
+func TestBatchCachedItemsNotRateLimited(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+
+	items := make([]string, maxBatchSize)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"endpoint":"/nft/%s/%d"}`, testContract, i)
+		if w := postProxy(p, items[i]); w.Code != http.StatusOK {
+			t.Fatalf("warm-up %d: status = %d", i, w.Code)
+		}
+	}
+
+	// The default limit's burst is well below maxBatchSize
+	p.limiter = newKeyedRateLimiter(defaultProxyRPS)
+	results := postBatch(t, p, "["+strings.Join(items, ",")+"]")
+	for i, res := range results {
+		if res.Status != http.StatusOK || !res.Cached {
+			t.Errorf("item %d: status %d cached %v, want 200 from cache", i, res.Status, res.Cached)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCacheTTL = 24 * time.Hour
+
+// Disk cache validity, configured once at startup by loadCacheTTLConfig
+var (
+	cacheTTL          = defaultCacheTTL
+	cacheTTLOverrides = map[string]time.Duration{}
+	cacheStaleMax     time.Duration // 0 disables stale-while-revalidate
+)
+
+// Disk cache size cap (0 = unlimited), configured by loadCacheSizeConfig
+var (
+	cacheMaxBytes int64
+	evictMu       sync.Mutex
+	evictPending  atomic.Bool // an eviction is queued and hasn't started scanning yet
+)
+
+// loadCacheTTLConfig reads CACHE_TTL (e.g. "6h", "30m"), CACHE_STALE_MAX and
+// the optional CACHE_TTL_OVERRIDES JSON object keyed by endpoint prefix,
+// e.g. {"/nft/0xabc/transfers": "1h"}.
+// Invalid values are logged and ignored so the server still starts.
+func loadCacheTTLConfig() {
+	if raw := os.Getenv("CACHE_TTL"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			cacheTTL = d
+		} else {
+			warnf("Warning: Invalid CACHE_TTL %q, using default %s", raw, defaultCacheTTL)
+		}
+	}
+	infof("Cache TTL: %s", cacheTTL)
+
+	// CACHE_STALE_MAX enables stale-while-revalidate: expired entries younger
+	// than this are served immediately while a background refresh runs.
+	if raw := os.Getenv("CACHE_STALE_MAX"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			cacheStaleMax = d
+			infof("Stale-while-revalidate enabled up to %s", cacheStaleMax)
+		} else {
+			warnf("Warning: Invalid CACHE_STALE_MAX %q, stale-while-revalidate disabled", raw)
+		}
+	}
+
+	raw := os.Getenv("CACHE_TTL_OVERRIDES")
+	if raw == "" {
+		return
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		warnf("Warning: Invalid CACHE_TTL_OVERRIDES JSON: %v", err)
+		return
+	}
+	for prefix, value := range overrides {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			warnf("Warning: Invalid cache TTL %q for %s, skipping", value, prefix)
+			continue
+		}
+		cacheTTLOverrides[prefix] = d
+		infof("Cache TTL override: %s -> %s", prefix, d)
+	}
+}
+
+// cacheTTLFor returns the cache validity for an endpoint.
+// The longest matching override prefix wins, otherwise the global TTL applies.
+func cacheTTLFor(endpoint string) time.Duration {
+	ttl := cacheTTL
+	matched := -1
+	for prefix, d := range cacheTTLOverrides {
+		if strings.HasPrefix(endpoint, prefix) && len(prefix) > matched {
+			ttl = d
+			matched = len(prefix)
+		}
+	}
+	return ttl
+}
+
+// loadCacheSizeConfig reads CACHE_MAX_BYTES, the total size cap for the
+// cache directory. Unset or 0 means unlimited.
+func loadCacheSizeConfig() {
+	raw := os.Getenv("CACHE_MAX_BYTES")
+	if raw == "" {
+		return
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		warnf("Warning: Invalid CACHE_MAX_BYTES %q, cache size is unlimited", raw)
+		return
+	}
+	cacheMaxBytes = n
+	infof("Cache size cap: %d bytes", cacheMaxBytes)
+}
+
+// evictCacheAsync enforces the size cap in the background so the proxy
+// response isn't blocked by directory scans. wg tracks the goroutine so
+// shutdown can wait for it.
+// Calls made while a scan is already queued are dropped, since that scan
+// will see their files too; a burst of writes costs at most two scans.
+func evictCacheAsync(dir string, wg *sync.WaitGroup) {
+	if cacheMaxBytes <= 0 {
+		return
+	}
+	if !evictPending.CompareAndSwap(false, true) {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		evictMu.Lock()
+		defer evictMu.Unlock()
+		evictPending.Store(false)
+		if err := evictCache(dir, cacheMaxBytes); err != nil {
+			warnf("Warning: Cache eviction failed: %v", err)
+		}
+	}()
+}
+
+// evictCache removes the least-recently-modified .json files in dir
+// until their total size is at most maxBytes.
+func evictCache(dir string, maxBytes int64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cacheFile
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // Removed concurrently
+		}
+		files = append(files, cacheFile{filepath.Join(dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	if total <= maxBytes {
+		return nil
+	}
+
+	// Oldest first
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	removed := 0
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			warnf("Warning: Failed to evict %s: %v", f.path, err)
+			continue
+		}
+		total -= f.size
+		removed++
+	}
+	infof("Evicted %d cache files, cache size now %d bytes", removed, total)
+	return nil
+}
+
+// cacheEntry is the on-disk cache format. Keeping the original request next
+// to the body makes files inspectable and lets entries be cleared by endpoint.
+type cacheEntry struct {
+	Endpoint string            `json:"endpoint"`
+	Params   map[string]string `json:"params,omitempty"`
+	CachedAt time.Time         `json:"cached_at"`
+	Body     json.RawMessage   `json:"body"`
+}
+
+// errCorruptCacheEntry is returned for cache files that aren't valid JSON,
+// e.g. left truncated by a crash.
+var errCorruptCacheEntry = errors.New("corrupt cache entry")
+
+// writeCacheEntry stores body wrapped in a cacheEntry. It writes a temp file
+// and renames it into place so readers never see a partial entry; the temp
+// name doesn't end in .json, so eviction and clearing skip it.
+func writeCacheEntry(path string, req proxyRequest, body []byte) error {
+	data, err := json.Marshal(cacheEntry{
+		Endpoint: req.Endpoint,
+		Params:   req.Params,
+		CachedAt: time.Now().UTC(),
+		Body:     body,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readCacheEntry loads a cache file. Files written before the envelope format
+// are bare response bodies; they come back with an empty Endpoint.
+// Files that aren't valid JSON are removed and reported as errCorruptCacheEntry,
+// so the caller treats them as a miss.
+func readCacheEntry(path string) (cacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err == nil && entry.Endpoint != "" && len(entry.Body) > 0 {
+		return entry, nil
+	}
+	if !json.Valid(data) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			warnf("Warning: Failed to remove corrupt cache file %s: %v", path, err)
+		}
+		return cacheEntry{}, errCorruptCacheEntry
+	}
+	return cacheEntry{Body: data}, nil
+}
+
+// readCacheBody returns the cached response body to serve.
+func readCacheBody(path string) ([]byte, error) {
+	entry, err := readCacheEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Body, nil
+}
+
+// clearCache removes cached responses from dir. With an empty prefix every
+// .json file is removed; otherwise only entries whose endpoint starts with
+// prefix (old bare-format files have no endpoint and are kept).
+func clearCache(dir, prefix string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if prefix != "" {
+			entry, err := readCacheEntry(path)
+			if err != nil || entry.Endpoint == "" || !strings.HasPrefix(entry.Endpoint, prefix) {
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				warnf("Warning: Failed to remove %s: %v", path, err)
+			}
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetCacheTTLConfig restores the default TTL config after a test that
+// calls loadCacheTTLConfig.
+func resetCacheTTLConfig(t *testing.T) {
+	t.Helper()
+	cacheTTL, cacheTTLOverrides, cacheStaleMax = defaultCacheTTL, map[string]time.Duration{}, 0
+	t.Cleanup(func() {
+		cacheTTL, cacheTTLOverrides, cacheStaleMax = defaultCacheTTL, map[string]time.Duration{}, 0
+	})
+}
+
+func TestCacheTTLDefault(t *testing.T) {
+	resetCacheTTLConfig(t)
+	t.Setenv("CACHE_TTL", "")
+	t.Setenv("CACHE_TTL_OVERRIDES", "")
+	loadCacheTTLConfig()
+
+	if got := cacheTTLFor("/nft/" + testContract); got != defaultCacheTTL {
+		t.Errorf("cacheTTLFor() = %s, want %s", got, defaultCacheTTL)
+	}
+}
+
+func TestCacheTTLFromEnv(t *testing.T) {
+	resetCacheTTLConfig(t)
+	t.Setenv("CACHE_TTL", "6h")
+	t.Setenv("CACHE_TTL_OVERRIDES", "")
+	loadCacheTTLConfig()
+
+	if got := cacheTTLFor("/nft/" + testContract); got != 6*time.Hour {
+		t.Errorf("cacheTTLFor() = %s, want 6h", got)
+	}
+}
+
+func TestCacheTTLInvalidEnvKeepsDefault(t *testing.T) {
+	resetCacheTTLConfig(t)
+	t.Setenv("CACHE_TTL", "soon")
+	t.Setenv("CACHE_TTL_OVERRIDES", `{"/nft/": "nope"}`)
+	loadCacheTTLConfig()
+
+	if got := cacheTTLFor("/nft/" + testContract); got != defaultCacheTTL {
+		t.Errorf("cacheTTLFor() = %s, want %s", got, defaultCacheTTL)
+	}
+}
+
+func TestCacheTTLOverridesLongestPrefix(t *testing.T) {
+	resetCacheTTLConfig(t)
+	t.Setenv("CACHE_TTL", "6h")
+	t.Setenv("CACHE_TTL_OVERRIDES", `{"/nft/": "2h", "/nft/`+testContract+`/transfers": "10m"}`)
+	loadCacheTTLConfig()
+
+	for endpoint, want := range map[string]time.Duration{
+		"/nft/" + testContract + "/transfers": 10 * time.Minute,
+		"/nft/" + testContract + "/owners":    2 * time.Hour,
+		"/" + testContract + "/nft":           6 * time.Hour,
+	} {
+		if got := cacheTTLFor(endpoint); got != want {
+			t.Errorf("cacheTTLFor(%s) = %s, want %s", endpoint, got, want)
+		}
+	}
+}
+
+// writeSizedCacheFiles creates n cache files of size bytes in dir, the
+// first one oldest, and returns their paths.
+func writeSizedCacheFiles(t *testing.T, dir string, n, size int) []string {
+	t.Helper()
+	var paths []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.json", i))
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		when := time.Now().Add(time.Duration(i-n) * time.Minute)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func assertEvicted(t *testing.T, paths []string, removed int) {
+	t.Helper()
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if gone := os.IsNotExist(err); gone != (i < removed) {
+			t.Errorf("file %d (oldest first): removed = %v, want %v", i, gone, i < removed)
+		}
+	}
+}
+
+func TestEvictCacheRemovesOldestFirst(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeSizedCacheFiles(t, dir, 5, 100)
+	// Not a cache file; must survive and not count towards the cap
+	other := filepath.Join(dir, "notes.txt")
+	os.WriteFile(other, []byte(strings.Repeat("x", 1000)), 0644)
+
+	if err := evictCache(dir, 250); err != nil {
+		t.Fatal(err)
+	}
+	assertEvicted(t, paths, 3)
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("non-cache file was removed: %v", err)
+	}
+}
+
+func TestEvictCacheAsyncCoalesces(t *testing.T) {
+	old := cacheMaxBytes
+	cacheMaxBytes = 250
+	t.Cleanup(func() { cacheMaxBytes = old })
+
+	dir := t.TempDir()
+	paths := writeSizedCacheFiles(t, dir, 5, 100)
+
+	// While one scan is queued behind the lock, further calls must not queue more
+	var wg sync.WaitGroup
+	evictMu.Lock()
+	for i := 0; i < 10; i++ {
+		evictCacheAsync(dir, &wg)
+	}
+	if !evictPending.Load() {
+		t.Error("expected an eviction to be pending")
+	}
+	evictMu.Unlock()
+	wg.Wait()
+
+	assertEvicted(t, paths, 3)
+}
+
+func TestCacheEntryRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "entry.json")
+	req := proxyRequest{Endpoint: "/nft/" + testContract, Params: map[string]string{"chain": "eth"}}
+
+	if err := writeCacheEntry(path, req, []byte(`{"result":[1]}`)); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := readCacheEntry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Endpoint != req.Endpoint || entry.Params["chain"] != "eth" || string(entry.Body) != `{"result":[1]}` || entry.CachedAt.IsZero() {
+		t.Errorf("round trip = %+v", entry)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files in cache dir, want only the entry (no temp files left)", len(files))
+	}
+}
+
+func TestCacheEntryLegacyBareFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.json")
+	os.WriteFile(path, []byte(`{"result":[2]}`), 0644)
+
+	entry, err := readCacheEntry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Endpoint != "" || string(entry.Body) != `{"result":[2]}` {
+		t.Errorf("legacy entry = %+v, want the bare body", entry)
+	}
+}
+
+func TestCacheEntryTruncatedIsMiss(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+	req := proxyRequest{Endpoint: "/nft/" + testContract}
+	path := p.cachePath(req)
+	os.WriteFile(path, []byte(`{"endpoint":"/nft/`+testContract+`","body":{"resu`), 0644)
+
+	if _, err := readCacheEntry(path); !errors.Is(err, errCorruptCacheEntry) {
+		t.Errorf("readCacheEntry() error = %v, want errCorruptCacheEntry", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("corrupt cache file was not removed")
+	}
+
+	// Served from Moralis instead of the broken file
+	os.WriteFile(path, []byte(`{"resu`), 0644)
+	w := postProxy(p, `{"endpoint":"`+req.Endpoint+`"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"result":[]}` {
+		t.Errorf("got %d %s, want the upstream body", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// loadCORSOrigins reads CORS_ALLOWED_ORIGINS, a comma-separated list of
+// origins (or "*") allowed to call the proxy from a browser.
+// Unset means same-origin only, as before.
+func loadCORSOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimRight(o, "/"))
+		}
+	}
+	if len(origins) > 0 {
+		infof("CORS allowed origins: %s", strings.Join(origins, ", "))
+	}
+	return origins
+}
+
+// withCORS adds CORS headers for allowed origins and answers OPTIONS
+// preflight requests with 204 before they reach next.
+func withCORS(allowed []string, next http.HandlerFunc) http.HandlerFunc {
+	// Responses depend on Origin unless every origin gets the same "*", so
+	// shared caches must not reuse one fetched without Origin for a CORS caller
+	varyOrigin := len(allowed) > 0 && !slices.Contains(allowed, "*")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if varyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		if allowOrigin := matchOrigin(allowed, origin); allowOrigin != "" {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", allowOrigin)
+			h.Set("Access-Control-Expose-Headers", requestIDHeader)
+			if r.Method == http.MethodOptions {
+				h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", "Content-Type, "+requestIDHeader)
+				h.Set("Access-Control-Max-Age", "86400")
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// matchOrigin returns the Access-Control-Allow-Origin value for origin,
+// or "" when it isn't allowed. With "*" allowed it's "*" even without an
+// Origin header, so a shared cache can hand the response to any caller.
+func matchOrigin(allowed []string, origin string) string {
+	if slices.Contains(allowed, "*") {
+		return "*"
+	}
+	if origin == "" {
+		return ""
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func corsRequest(allowed []string, method, origin string) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	h := withCORS(allowed, func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	})
+	r := httptest.NewRequest(method, "/api/proxy", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w, reached
+}
+
+func TestCORSPreflight(t *testing.T) {
+	w, reached := corsRequest([]string{"https://app.example"}, http.MethodOptions, "https://app.example")
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", w.Code)
+	}
+	if reached {
+		t.Error("preflight reached the proxy handler")
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example",
+		"Access-Control-Allow-Methods": "GET, HEAD, POST, OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type, " + requestIDHeader,
+		"Access-Control-Max-Age":       "86400",
+		"Vary":                         "Origin",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCORSVary(t *testing.T) {
+	for _, tc := range []struct {
+		allowed   []string
+		origin    string
+		wantVary  string
+		wantAllow string
+	}{
+		// Fetched without Origin, but must not be reused for a cross-origin caller
+		{[]string{"https://app.example"}, "", "Origin", ""},
+		{[]string{"https://app.example"}, "https://evil.example", "Origin", ""},
+		{[]string{"https://app.example"}, "https://app.example", "Origin", "https://app.example"},
+		{[]string{"*"}, "https://app.example", "", "*"},
+		// Cacheable for everyone, so "*" is sent even without Origin
+		{[]string{"*"}, "", "", "*"},
+		{nil, "https://app.example", "", ""},
+	} {
+		w, reached := corsRequest(tc.allowed, http.MethodGet, tc.origin)
+		if !reached {
+			t.Errorf("allowed %q, origin %q: GET didn't reach the handler", tc.allowed, tc.origin)
+		}
+		if got := w.Header().Get("Vary"); got != tc.wantVary {
+			t.Errorf("allowed %q, origin %q: Vary = %q, want %q", tc.allowed, tc.origin, got, tc.wantVary)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.wantAllow {
+			t.Errorf("allowed %q, origin %q: Access-Control-Allow-Origin = %q, want %q", tc.allowed, tc.origin, got, tc.wantAllow)
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	w, _ := corsRequest([]string{"https://app.example"}, http.MethodOptions, "https://evil.example")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q for a disallowed origin", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// logLevel gates log volume; configured by LOG_LEVEL (debug, info, warn, error).
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var currentLogLevel = levelInfo
+
+// loadLogLevel reads LOG_LEVEL. Unknown values keep the default (info).
+func loadLogLevel() {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL")))
+	switch raw {
+	case "":
+	case "debug":
+		currentLogLevel = levelDebug
+	case "info":
+		currentLogLevel = levelInfo
+	case "warn", "warning":
+		currentLogLevel = levelWarn
+	case "error":
+		currentLogLevel = levelError
+	default:
+		log.Printf("Warning: Unknown LOG_LEVEL %q, using info", raw)
+	}
+}
+
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < currentLogLevel {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLogs redirects the standard logger into a buffer for one test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLogLevelWarnSuppressesInfo(t *testing.T) {
+	old := currentLogLevel
+	t.Cleanup(func() { currentLogLevel = old })
+	t.Setenv("LOG_LEVEL", "warn")
+	loadLogLevel()
+	logs := captureLogs(t)
+
+	debugf("debug line")
+	infof("info line")
+	requestLogger{id: "r1"}.Infof("request info line")
+	warnf("warn line")
+	requestLogger{id: "r1"}.Errorf("request error line")
+
+	out := logs.String()
+	for _, hidden := range []string{"debug line", "info line"} {
+		if strings.Contains(out, hidden) {
+			t.Errorf("%q logged at LOG_LEVEL=warn:\n%s", hidden, out)
+		}
+	}
+	for _, shown := range []string{"warn line", "[r1] request error line"} {
+		if !strings.Contains(out, shown) {
+			t.Errorf("%q missing at LOG_LEVEL=warn:\n%s", shown, out)
+		}
+	}
+}
+
+func TestLogLevelUnknownKeepsInfo(t *testing.T) {
+	old := currentLogLevel
+	t.Cleanup(func() { currentLogLevel = old })
+	currentLogLevel = levelInfo
+	t.Setenv("LOG_LEVEL", "verbose")
+	captureLogs(t)
+	loadLogLevel()
+
+	if currentLogLevel != levelInfo {
+		t.Errorf("currentLogLevel = %d, want info", currentLogLevel)
+	}
+}
@@ -1,165 +1,163 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
+const (
+	defaultMoralisBaseURL  = "https://deep-index.moralis.io/api/v2"
+	shutdownTimeout        = 10 * time.Second
+	defaultUpstreamTimeout = 30 * time.Second
+)
+
+// loadMoralisBaseURL reads MORALIS_BASE_URL, which can override the Moralis
+// base URL (e.g. newer API version or a staging gateway).
+// Trailing slashes are trimmed so both ".../v2" and ".../v2/" work.
+func loadMoralisBaseURL() string {
+	baseURL := strings.TrimRight(os.Getenv("MORALIS_BASE_URL"), "/")
+	if baseURL == "" {
+		return defaultMoralisBaseURL
+	}
+	return baseURL
+}
+
+// handleHealthz is the /healthz endpoint. It never calls Moralis,
+// only reports whether a key is configured.
+func handleHealthz(keys *apiKeyPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":             "ok",
+			"moralis_key_loaded": keys.size() > 0,
+		})
+	}
+}
+
+// serve runs srv on ln until ctx is cancelled, then drains in-flight requests
+// and waits for the proxy's background cache work, bounded by shutdownTimeout.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, proxy *moralisProxy) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	infof("Shutdown signal received, draining connections...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		warnf("Warning: Graceful shutdown failed: %v", err)
+	}
+	if err := proxy.waitBackground(shutdownCtx); err != nil {
+		warnf("Warning: Background cache work did not finish: %v", err)
+	}
+	infof("Server stopped")
+	return nil
+}
+
 func main() {
+	// Log verbosity first, so every later line respects it
+	loadLogLevel()
+	infof("Starting server version=%s commit=%s built=%s", version, gitCommit, buildTime)
+
 	// Determine port
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	// 1. Get API Key securely from Environment Variable
-	// TrimSpace removes any accidental newlines or spaces from the secret
-	rawKey := os.Getenv("MORALIS_API_KEY")
-	apiKey := strings.TrimSpace(rawKey)
+	// 1. Get API Key(s) securely from MORALIS_API_KEYS, a mounted secret file or Environment Variable
+	keys := loadAPIKeyPool()
 
-	if apiKey == "" {
-		log.Println("Warning: MORALIS_API_KEY is not set (empty). API calls will fail.")
+	if keys.size() == 0 {
+		warnf("Warning: MORALIS_API_KEY is not set (empty). API calls will fail.")
 	} else {
-		// Log length only for security
-		log.Printf("Moralis API Key loaded successfully. Length: %d characters", len(apiKey))
+		// Log count only for security
+		infof("Moralis API Keys loaded successfully. Count: %d", keys.size())
+	}
+
+	baseURL := loadMoralisBaseURL()
+	infof("Using Moralis base URL: %s", baseURL)
+
+	// Timeout for calls to Moralis so a hung connection can't tie up a handler
+	upstreamTimeout := defaultUpstreamTimeout
+	if raw := os.Getenv("UPSTREAM_TIMEOUT"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			upstreamTimeout = d
+		} else {
+			warnf("Warning: Invalid UPSTREAM_TIMEOUT %q, using default %s", raw, defaultUpstreamTimeout)
+		}
 	}
+	infof("Upstream timeout: %s", upstreamTimeout)
 
-	// Serve static files
-	staticDir := "static"
-	if _, err := os.Stat(staticDir); os.IsNotExist(err) {
-		log.Printf("Warning: 'static' directory not found.")
+	// Serve static files (STATIC_DIR overrides the default "static")
+	staticDir := os.Getenv("STATIC_DIR")
+	if staticDir == "" {
+		staticDir = defaultStaticDir
 	}
-	fs := http.FileServer(http.Dir(staticDir))
-	http.Handle("/", fs)
+	http.Handle("/", staticHandler(staticDir))
 
 	// Create cache directory
 	cacheDir := "api_cache"
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		log.Printf("Warning: Failed to create cache directory: %v", err)
+		warnf("Warning: Failed to create cache directory: %v", err)
 	}
+	loadCacheTTLConfig()
+	loadCacheSizeConfig()
+	limiter := loadProxyRateLimiter()
 
-	// 2. API Proxy Endpoint
-	http.HandleFunc("/api/proxy", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		// Read request body from frontend
-		// Expected JSON: { "endpoint": "/nft/...", "params": { ... } }
-		var reqBody struct {
-			Endpoint string            `json:"endpoint"`
-			Params   map[string]string `json:"params"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-
-		// --- Caching Logic Start ---
-		// 1. Generate Cache Key (SHA256 of JSON body)
-		// Go's json.Marshal sorts map keys, so it's deterministic enough for this.
-		reqBytes, _ := json.Marshal(reqBody)
-		hash := sha256.Sum256(reqBytes)
-		cacheKey := hex.EncodeToString(hash[:])
-		cachePath := filepath.Join(cacheDir, cacheKey+".json")
-
-		// 2. Check for Valid Cache
-		if info, err := os.Stat(cachePath); err == nil {
-			// Cache exists, check age
-			if time.Since(info.ModTime()) < 24*time.Hour {
-				// Cache is valid (< 24h)
-				log.Printf("Serving from cache: %s", reqBody.Endpoint)
-				data, err := os.ReadFile(cachePath)
-				if err == nil {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusOK)
-					w.Write(data)
-					return
-				}
-				// If read fails, fall through to fetch
-			}
-		}
-		// --- Caching Logic End ---
-
-		// Construct Moralis API URL
-		baseURL := "https://deep-index.moralis.io/api/v2"
-		targetURL := baseURL + reqBody.Endpoint
-
-		// Add query parameters
-		if len(reqBody.Params) > 0 {
-			targetURL += "?"
-			for k, v := range reqBody.Params {
-				targetURL += k + "=" + v + "&"
-			}
-		}
+	// Health check endpoint for load balancers / Cloud Run
+	http.HandleFunc("/healthz", handleHealthz(keys))
 
-		// Create request to Moralis
-		proxyReq, err := http.NewRequest("GET", targetURL, nil)
-		if err != nil {
-			http.Error(w, "Failed to create request", http.StatusInternalServerError)
-			return
-		}
+	// Build info (set via -ldflags)
+	http.HandleFunc("/version", handleVersion)
 
-		// Add Secure Headers
-		proxyReq.Header.Set("X-API-Key", apiKey)
-		proxyReq.Header.Set("Content-Type", "application/json")
-		proxyReq.Header.Set("accept", "application/json")
-
-		// Execute request
-		client := &http.Client{}
-		resp, err := client.Do(proxyReq)
-		if err != nil {
-			log.Printf("Proxy Error: Failed to reach Moralis API: %v", err)
-			http.Error(w, "Failed to reach Moralis API", http.StatusBadGateway)
-			return
-		}
-		defer resp.Body.Close()
-
-		// Check for upstream errors and log them
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			log.Printf("Moralis API Error: Status %d, Body: %s", resp.StatusCode, string(bodyBytes))
-
-			// Forward the error status and body to frontend for debugging
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(resp.StatusCode)
-			w.Write(bodyBytes)
-			return
-		}
+	// 2. API Proxy Endpoint
+	proxy := &moralisProxy{
+		keys:       keys,
+		baseURL:    baseURL,
+		cacheDir:   cacheDir,
+		client:     &http.Client{Timeout: upstreamTimeout},
+		limiter:    limiter,
+		adminToken: strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+	}
+	http.HandleFunc("/api/proxy", withCORS(loadCORSOrigins(), proxy.handleProxy))
 
-		// Read response body for caching
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			http.Error(w, "Error reading response", http.StatusInternalServerError)
-			return
-		}
+	// Admin: purge the disk cache (requires X-Admin-Token)
+	http.HandleFunc("/api/cache/clear", proxy.handleCacheClear)
 
-		// Save to Cache
-		if err := os.WriteFile(cachePath, bodyBytes, 0644); err != nil {
-			log.Printf("Warning: Failed to write cache: %v", err)
-		} else {
-			log.Printf("Cached response for: %s", reqBody.Endpoint)
-		}
+	// Prometheus metrics for the proxy
+	http.HandleFunc("/metrics", metrics.handleMetrics)
 
-		// Copy success response back to frontend
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		w.Write(bodyBytes)
-	})
+	// 3. Start server with graceful shutdown
+	// Cloud Run sends SIGTERM before stopping the instance; in-flight requests
+	// and background cache refreshes are given time to finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Listening on port %s", port)
-	log.Printf("Open http://localhost:%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	srv := &http.Server{Addr: ":" + port}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	infof("Listening on port %s", port)
+	infof("Open http://localhost:%s", port)
+	if err := serve(ctx, srv, ln, proxy); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoralisBaseURLTrailingSlash(t *testing.T) {
+	var gotPath string
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		okUpstream(w, r)
+	})
+	mockURL := p.baseURL
+
+	for _, base := range []string{mockURL + "/api/v2", mockURL + "/api/v2/"} {
+		t.Setenv("MORALIS_BASE_URL", base)
+		p.baseURL = loadMoralisBaseURL()
+		gotPath = ""
+
+		w := postProxy(p, `{"endpoint":"/nft/`+testContract+`/owners"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("base %q: status = %d, want 200", base, w.Code)
+		}
+		if want := "/api/v2/nft/" + testContract + "/owners"; gotPath != want {
+			t.Errorf("base %q: upstream path = %q, want %q", base, gotPath, want)
+		}
+		clearCache(p.cacheDir, "")
+	}
+}
+
+func TestMoralisBaseURLDefault(t *testing.T) {
+	t.Setenv("MORALIS_BASE_URL", "")
+	if got := loadMoralisBaseURL(); got != defaultMoralisBaseURL {
+		t.Errorf("loadMoralisBaseURL() = %q, want %q", got, defaultMoralisBaseURL)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	for _, tc := range []struct {
+		keys []string
+		want bool
+	}{
+		{[]string{"k"}, true},
+		{nil, false},
+	} {
+		w := httptest.NewRecorder()
+		handleHealthz(&apiKeyPool{keys: tc.keys})(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+		}
+		if len(got) != 2 || got["status"] != "ok" || got["moralis_key_loaded"] != tc.want {
+			t.Errorf("body = %v, want status ok and moralis_key_loaded %v", got, tc.want)
+		}
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	setCacheTTL(t, time.Minute, time.Hour)
+
+	started := make(chan struct{}, 2)
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		// The refresh outlives the in-flight request, so only waitBackground covers it
+		if strings.HasSuffix(r.URL.Path, "/owners") {
+			time.Sleep(400 * time.Millisecond)
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte(`{"result":["new"]}`))
+	})
+
+	// A stale entry: served at once, refreshed in the background
+	staleReq := proxyRequest{Endpoint: "/nft/" + testContract + "/owners"}
+	stalePath := writeAgedCache(t, p, staleReq, `{"result":["old"]}`, 2*time.Minute)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/proxy", p.handleProxy)
+	srv := &http.Server{Handler: mux}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, p) }()
+
+	base := "http://" + ln.Addr().String() + "/api/proxy?endpoint="
+	resp, err := http.Get(base + staleReq.Endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != `{"result":["old"]}` {
+		t.Fatalf("stale body = %s", body)
+	}
+	<-started
+
+	// An in-flight request when the shutdown starts
+	inflight := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(base + "/nft/" + testContract + "/transfers")
+		if err != nil {
+			inflight <- 0
+			return
+		}
+		resp.Body.Close()
+		inflight <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	if err := <-served; err != nil {
+		t.Fatalf("serve() = %v", err)
+	}
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("listener still accepts connections after serve() returned")
+	}
+	if code := <-inflight; code != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want 200", code)
+	}
+	if got, err := readCacheBody(stalePath); err != nil || string(got) != `{"result":["new"]}` {
+		t.Errorf("refreshed cache = %s, %v; want the new body", got, err)
+	}
+}
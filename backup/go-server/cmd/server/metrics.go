@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds (seconds) of the upstream duration histogram buckets
+var upstreamDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// proxyMetrics holds the counters exposed on /metrics in the Prometheus
+// text format. Kept dependency-free since the server only needs a handful.
+type proxyMetrics struct {
+	requests    atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	mu             sync.Mutex
+	upstreamErrors map[string]int64 // status code (or "network") -> count
+	durationCounts []int64          // per bucket, non-cumulative; last is +Inf
+	durationSum    float64
+	durationCount  int64
+}
+
+var metrics = newProxyMetrics()
+
+func newProxyMetrics() *proxyMetrics {
+	return &proxyMetrics{
+		upstreamErrors: make(map[string]int64),
+		durationCounts: make([]int64, len(upstreamDurationBuckets)+1),
+	}
+}
+
+// observeUpstream records one upstream call. status is 0 when Moralis
+// could not be reached.
+func (m *proxyMetrics) observeUpstream(status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(upstreamDurationBuckets, secs)
+	m.durationCounts[i]++
+	m.durationSum += secs
+	m.durationCount++
+
+	switch {
+	case status == 0:
+		m.upstreamErrors["network"]++
+	case status != http.StatusOK:
+		m.upstreamErrors[strconv.Itoa(status)]++
+	}
+}
+
+// handleMetrics is the /metrics endpoint.
+func (m *proxyMetrics) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP proxy_requests_total Requests received on /api/proxy.")
+	fmt.Fprintln(w, "# TYPE proxy_requests_total counter")
+	fmt.Fprintf(w, "proxy_requests_total %d\n", m.requests.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_cache_hits_total Proxy requests served from the disk cache.")
+	fmt.Fprintln(w, "# TYPE proxy_cache_hits_total counter")
+	fmt.Fprintf(w, "proxy_cache_hits_total %d\n", m.cacheHits.Load())
+
+	fmt.Fprintln(w, "# HELP proxy_cache_misses_total Proxy requests that had to call Moralis.")
+	fmt.Fprintln(w, "# TYPE proxy_cache_misses_total counter")
+	fmt.Fprintf(w, "proxy_cache_misses_total %d\n", m.cacheMisses.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP proxy_upstream_errors_total Failed Moralis calls by status code.")
+	fmt.Fprintln(w, "# TYPE proxy_upstream_errors_total counter")
+	codes := make([]string, 0, len(m.upstreamErrors))
+	for code := range m.upstreamErrors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "proxy_upstream_errors_total{status=%q} %d\n", code, m.upstreamErrors[code])
+	}
+
+	fmt.Fprintln(w, "# HELP proxy_upstream_request_duration_seconds Duration of Moralis calls.")
+	fmt.Fprintln(w, "# TYPE proxy_upstream_request_duration_seconds histogram")
+	var cumulative int64
+	for i, le := range upstreamDurationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(w, "proxy_upstream_request_duration_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	cumulative += m.durationCounts[len(upstreamDurationBuckets)]
+	fmt.Fprintf(w, "proxy_upstream_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "proxy_upstream_request_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "proxy_upstream_request_duration_seconds_count %d\n", m.durationCount)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsCacheHitAndMiss(t *testing.T) {
+	old := metrics
+	metrics = newProxyMetrics()
+	t.Cleanup(func() { metrics = old })
+
+	p := newTestProxy(t, okUpstream)
+	body := `{"endpoint":"/nft/` + testContract + `"}`
+	postProxy(p, body) // miss
+	postProxy(p, body) // hit
+
+	w := httptest.NewRecorder()
+	metrics.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+
+	for _, want := range []string{
+		"proxy_requests_total 2\n",
+		"proxy_cache_hits_total 1\n",
+		"proxy_cache_misses_total 1\n",
+		"proxy_upstream_request_duration_seconds_count 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output is missing %q:\n%s", want, out)
+		}
+	}
+}
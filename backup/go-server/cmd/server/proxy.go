@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Delays between upstream retries; its length is the number of retries
+var upstreamRetryBackoff = []time.Duration{500 * time.Millisecond, time.Second}
+
+// allowedEndpoints restricts which Moralis routes the proxy will forward.
+// Anything else is rejected so the API key can't be used for arbitrary (billable) calls.
+var allowedEndpoints = []*regexp.Regexp{
+	regexp.MustCompile(`^/nft/0x[0-9a-fA-F]{40}$`),                           // Collection NFTs
+	regexp.MustCompile(`^/nft/0x[0-9a-fA-F]{40}/(transfers|owners)$`),        // Collection transfers / owners
+	regexp.MustCompile(`^/nft/0x[0-9a-fA-F]{40}/[0-9]+$`),                    // Token metadata
+	regexp.MustCompile(`^/nft/0x[0-9a-fA-F]{40}/[0-9]+/(transfers|owners)$`), // Token transfers / owners
+}
+
+// isAllowedEndpoint reports whether endpoint matches one of allowedEndpoints.
+func isAllowedEndpoint(endpoint string) bool {
+	for _, re := range allowedEndpoints {
+		if re.MatchString(endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONError sends an error as {"error": "...", "code": N} so the frontend
+// can always JSON.parse proxy responses.
+func writeJSONError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": message,
+		"code":  code,
+	})
+}
+
+// errClientRateLimited is returned by resolve when a cache miss would exceed
+// the client's rate limit.
+var errClientRateLimited = errors.New("client rate limit exceeded")
+
+// errBuildRequest marks a failure to construct the Moralis request, which is
+// a problem on our side (e.g. a bad MORALIS_BASE_URL), not an unreachable Moralis.
+var errBuildRequest = errors.New("build upstream request")
+
+// upstreamFailure maps an error from fetch to the status and message sent
+// to the frontend: 500 when the request couldn't be built, 504 when Moralis
+// didn't answer in time, 502 otherwise.
+func upstreamFailure(err error) (int, string) {
+	if errors.Is(err, errBuildRequest) {
+		return http.StatusInternalServerError, "failed to build upstream request"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout, "moralis api timed out"
+	}
+	if errors.Is(err, errClientRateLimited) {
+		return http.StatusTooManyRequests, "rate limit exceeded"
+	}
+	if errors.Is(err, errKeysRateLimited) {
+		return http.StatusTooManyRequests, "moralis api key rate limit exceeded"
+	}
+	return http.StatusBadGateway, "failed to reach moralis api"
+}
+
+// proxyRequest is the body the frontend posts to /api/proxy.
+// Expected JSON: { "endpoint": "/nft/...", "params": { ... } }
+type proxyRequest struct {
+	Endpoint string            `json:"endpoint"`
+	Params   map[string]string `json:"params"`
+}
+
+// moralisProxy forwards allowlisted requests to Moralis and caches
+// successful responses on disk.
+type moralisProxy struct {
+	keys       *apiKeyPool
+	baseURL    string
+	cacheDir   string
+	client     *http.Client
+	limiter    *keyedRateLimiter // nil disables rate limiting
+	adminToken string            // empty disables admin endpoints
+
+	refreshing sync.Map           // cache path -> struct{}, background refreshes in flight
+	inflight   singleflight.Group // cache path -> upstream fetch in progress
+	background sync.WaitGroup     // refreshes and evictions, waited for on shutdown
+}
+
+// cachePath returns the cache file for a request (SHA256 of the JSON body).
+// Go's json.Marshal sorts map keys, so it's deterministic enough for this.
+func (p *moralisProxy) cachePath(req proxyRequest) string {
+	reqBytes, _ := json.Marshal(req)
+	hash := sha256.Sum256(reqBytes)
+	return filepath.Join(p.cacheDir, hex.EncodeToString(hash[:])+".json")
+}
+
+// targetURL builds the Moralis URL for a request.
+func (p *moralisProxy) targetURL(req proxyRequest) string {
+	targetURL := p.baseURL + req.Endpoint
+
+	// Add query parameters (escaped, so cursors containing '&', '+' or '=' survive)
+	if len(req.Params) > 0 {
+		query := url.Values{}
+		for k, v := range req.Params {
+			query.Set(k, v)
+		}
+		targetURL += "?" + query.Encode()
+	}
+	return targetURL
+}
+
+// fetch calls Moralis, retrying transient failures (5xx and network errors)
+// with backoff, and returns the last status code and body.
+// A non-nil error means Moralis could not be reached at all.
+// Backoff waits end early when ctx is done, e.g. the client went away.
+func (p *moralisProxy) fetch(ctx context.Context, req proxyRequest, logger requestLogger) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		status, body, err := p.fetchOnce(req)
+		if attempt >= len(upstreamRetryBackoff) || !isRetryableUpstream(status, err) {
+			return status, body, err
+		}
+		wait := upstreamRetryBackoff[attempt]
+		if err != nil {
+			logger.Warnf("Retry %d/%d for %s in %s: %v", attempt+1, len(upstreamRetryBackoff), req.Endpoint, wait, err)
+		} else {
+			logger.Warnf("Retry %d/%d for %s in %s: status %d", attempt+1, len(upstreamRetryBackoff), req.Endpoint, wait, status)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+// isRetryableUpstream reports whether a fetchOnce outcome is worth retrying:
+// 5xx responses and transport errors. Local errors (building the request,
+// key rate limits) would fail the same way again. Timeouts are not retried
+// so a hung Moralis can't hold a handler for several full UPSTREAM_TIMEOUT periods.
+func isRetryableUpstream(status int, err error) bool {
+	if err == nil {
+		return status >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// fetchOnce performs a single Moralis call with the next key in rotation.
+// Moralis also returns 401 for some endpoints regardless of the key (e.g.
+// single-token metadata), so with several keys a 401 is retried once with
+// the next one, and the first key only gets a strike if that retry succeeds.
+func (p *moralisProxy) fetchOnce(req proxyRequest) (int, []byte, error) {
+	apiKey, err := p.keys.pick()
+	if err != nil {
+		return 0, nil, err
+	}
+	status, body, err := p.fetchWithKey(req, apiKey)
+	if err != nil || status != http.StatusUnauthorized {
+		if status == http.StatusOK {
+			p.keys.succeeded(apiKey)
+		}
+		return status, body, err
+	}
+
+	other, err := p.keys.pick()
+	if err != nil || other == apiKey {
+		return status, body, nil
+	}
+	status, body, err = p.fetchWithKey(req, other)
+	if err == nil && status == http.StatusOK {
+		p.keys.succeeded(other)
+		p.keys.strike(apiKey)
+	}
+	return status, body, err
+}
+
+// fetchWithKey performs one Moralis call authenticated with apiKey.
+func (p *moralisProxy) fetchWithKey(req proxyRequest, apiKey string) (int, []byte, error) {
+	proxyReq, err := http.NewRequest("GET", p.targetURL(req), nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", errBuildRequest, err)
+	}
+
+	// Add Secure Headers
+	proxyReq.Header.Set("X-API-Key", apiKey)
+	proxyReq.Header.Set("Content-Type", "application/json")
+	proxyReq.Header.Set("accept", "application/json")
+
+	start := time.Now()
+	resp, err := p.client.Do(proxyReq)
+	if err != nil {
+		metrics.observeUpstream(0, time.Since(start))
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	metrics.observeUpstream(resp.StatusCode, time.Since(start))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read response: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// writeCache saves a successful response and triggers size-cap eviction.
+func (p *moralisProxy) writeCache(path string, req proxyRequest, body []byte, logger requestLogger) {
+	if err := writeCacheEntry(path, req, body); err != nil {
+		logger.Warnf("Warning: Failed to write cache: %v", err)
+		return
+	}
+	logger.Debugf("Cached response for: %s", req.Endpoint)
+	evictCacheAsync(p.cacheDir, &p.background)
+}
+
+// refreshInBackground re-fetches a stale cache entry without blocking the caller.
+// Only one refresh per cache file runs at a time.
+func (p *moralisProxy) refreshInBackground(req proxyRequest, path string, logger requestLogger) {
+	if _, busy := p.refreshing.LoadOrStore(path, struct{}{}); busy {
+		return
+	}
+	p.background.Add(1)
+	go func() {
+		defer p.background.Done()
+		defer p.refreshing.Delete(path)
+
+		status, body, err := p.fetch(context.Background(), req, logger)
+		if err != nil {
+			logger.Warnf("Background refresh failed for %s: %v", req.Endpoint, err)
+			return
+		}
+		if status != http.StatusOK {
+			logger.Warnf("Background refresh for %s got status %d, keeping stale cache", req.Endpoint, status)
+			return
+		}
+		if isMoralisErrorEnvelope(body) {
+			logger.Warnf("Background refresh for %s got an error body, keeping stale cache", req.Endpoint)
+			return
+		}
+		p.writeCache(path, req, body, logger)
+	}()
+}
+
+// waitBackground waits for background refreshes and evictions to finish so
+// shutdown doesn't cut a cache write short. It gives up when ctx is done.
+func (p *moralisProxy) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isMoralisErrorEnvelope reports whether a 200 body is actually a Moralis
+// error, i.e. a JSON object with a "message" field but no "result".
+func isMoralisErrorEnvelope(body []byte) bool {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return false
+	}
+	_, hasMessage := envelope["message"]
+	_, hasResult := envelope["result"]
+	return hasMessage && !hasResult
+}
+
+// proxyResult is the outcome of resolving a single proxy request.
+type proxyResult struct {
+	status int
+	body   []byte
+	cached bool
+	maxAge time.Duration // how long the browser may reuse a 200 response
+}
+
+// resolve answers a request from the disk cache when possible, otherwise
+// from Moralis. Successful upstream responses are written to the cache.
+// A non-nil error means Moralis could not be reached.
+// When client is set, a cache miss costs it one rate-limit token; callers
+// that already charged the request pass "".
+func (p *moralisProxy) resolve(ctx context.Context, req proxyRequest, client string, logger requestLogger) (proxyResult, error) {
+	// --- Caching Logic Start ---
+	cachePath := p.cachePath(req)
+
+	if info, err := os.Stat(cachePath); err == nil {
+		// Cache exists, check age
+		age := time.Since(info.ModTime())
+		ttl := cacheTTLFor(req.Endpoint)
+		fresh := age < ttl
+		stale := !fresh && cacheStaleMax > 0 && age < cacheStaleMax
+
+		if fresh || stale {
+			data, err := readCacheBody(cachePath)
+			if err == nil {
+				if fresh {
+					logger.Debugf("Serving from cache: %s", req.Endpoint)
+				} else {
+					// Stale-while-revalidate: answer now, refresh for the next caller
+					logger.Debugf("Serving stale cache (age %s) and refreshing: %s", age.Round(time.Second), req.Endpoint)
+					p.refreshInBackground(req, cachePath, logger)
+				}
+				metrics.cacheHits.Add(1)
+				// Stale entries get max-age 0 so the browser comes back for the refreshed copy
+				var maxAge time.Duration
+				if fresh {
+					maxAge = ttl - age
+				}
+				return proxyResult{status: http.StatusOK, body: data, cached: true, maxAge: maxAge}, nil
+			}
+			// If read fails, fall through to fetch
+		}
+	}
+	// --- Caching Logic End ---
+	metrics.cacheMisses.Add(1)
+
+	if client != "" && p.limiter != nil {
+		if ok, _ := p.limiter.allow(client); !ok {
+			return proxyResult{}, errClientRateLimited
+		}
+	}
+
+	// Identical requests arriving before the cache is written share one upstream call.
+	// The call is detached from this caller's context since others may be waiting
+	// on it; a caller that goes away just stops waiting.
+	ch := p.inflight.DoChan(cachePath, func() (interface{}, error) {
+		return p.fetchAndCache(context.WithoutCancel(ctx), req, cachePath, logger)
+	})
+	select {
+	case res := <-ch:
+		if res.Shared {
+			logger.Debugf("Shared in-flight upstream call: %s", req.Endpoint)
+		}
+		return res.Val.(proxyResult), res.Err
+	case <-ctx.Done():
+		return proxyResult{}, ctx.Err()
+	}
+}
+
+// fetchAndCache calls Moralis and caches a successful response.
+func (p *moralisProxy) fetchAndCache(ctx context.Context, req proxyRequest, cachePath string, logger requestLogger) (proxyResult, error) {
+	status, body, err := p.fetch(ctx, req, logger)
+	if err != nil {
+		return proxyResult{}, err
+	}
+
+	// Check for upstream errors and log them
+	if status != http.StatusOK {
+		logger.Errorf("Moralis API Error: Status %d, Body: %s", status, string(body))
+		return proxyResult{status: status, body: body}, nil
+	}
+
+	// Moralis sometimes reports errors with a 200; don't cache those
+	if isMoralisErrorEnvelope(body) {
+		logger.Errorf("Moralis API Error (200 with error body): %s", string(body))
+		return proxyResult{status: http.StatusBadGateway, body: body}, nil
+	}
+
+	p.writeCache(cachePath, req, body, logger)
+	return proxyResult{status: status, body: body, maxAge: cacheTTLFor(req.Endpoint)}, nil
+}
+
+// getParamPrefix marks query parameters of GET /api/proxy that are forwarded to Moralis.
+const getParamPrefix = "p."
+
+// parseGetRequest builds a proxyRequest from GET /api/proxy?endpoint=...&p.chain=eth.
+// Params stays nil when none are given so the cache key matches the equivalent POST.
+func parseGetRequest(r *http.Request) proxyRequest {
+	query := r.URL.Query()
+	req := proxyRequest{Endpoint: query.Get("endpoint")}
+	for key, values := range query {
+		name, ok := strings.CutPrefix(key, getParamPrefix)
+		if !ok || name == "" || len(values) == 0 {
+			continue
+		}
+		if req.Params == nil {
+			req.Params = make(map[string]string)
+		}
+		req.Params[name] = values[0]
+	}
+	return req
+}
+
+// handleProxy is the /api/proxy endpoint.
+// POST takes a single proxyRequest or an array of them (batch mode) as JSON;
+// GET takes the endpoint and "p."-prefixed params in the query string.
+func (p *moralisProxy) handleProxy(w http.ResponseWriter, r *http.Request) {
+	// Tag the request so its log lines can be correlated with the frontend
+	logger := requestLogger{id: requestID(r)}
+	w.Header().Set(requestIDHeader, logger.id)
+	metrics.requests.Add(1)
+
+	// HEAD is a cheap uptime check: headers only, no Moralis call
+	if r.Method == http.MethodHead {
+		w.Header().Set("Cache-Control", "no-store")
+		if p.keys.size() == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if p.rateLimited(w, r) {
+			return
+		}
+		reqBody := parseGetRequest(r)
+		if reqBody.Endpoint == "" {
+			writeJSONError(w, "missing endpoint", http.StatusBadRequest)
+			return
+		}
+		p.serveSingle(w, r, reqBody, logger)
+		return
+	}
+
+	// Read request body from frontend
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeJSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		// Rate limited per item, since each one can be a Moralis call
+		p.handleBatch(w, r, raw, logger)
+		return
+	}
+	if p.rateLimited(w, r) {
+		return
+	}
+
+	var reqBody proxyRequest
+	if err := json.Unmarshal(raw, &reqBody); err != nil {
+		writeJSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	p.serveSingle(w, r, reqBody, logger)
+}
+
+// rateLimited applies the per-client rate limit that protects the Moralis
+// quota. When the client is over it, it writes a 429 and returns true.
+func (p *moralisProxy) rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	if p.limiter == nil {
+		return false
+	}
+	ok, wait := p.limiter.allow(clientIP(r))
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSONError(w, "rate limit exceeded", http.StatusTooManyRequests)
+	return true
+}
+
+// serveSingle resolves one request and writes the response.
+func (p *moralisProxy) serveSingle(w http.ResponseWriter, r *http.Request, reqBody proxyRequest, logger requestLogger) {
+	// Only forward allowlisted Moralis endpoints
+	if !isAllowedEndpoint(reqBody.Endpoint) {
+		logger.Warnf("Rejected endpoint: %s", reqBody.Endpoint)
+		writeJSONError(w, "endpoint not allowed", http.StatusForbidden)
+		return
+	}
+
+	res, err := p.resolve(r.Context(), reqBody, "", logger)
+	if err != nil {
+		logger.Errorf("Proxy Error: Moralis request failed: %v", err)
+		code, message := upstreamFailure(err)
+		writeJSONError(w, message, code)
+		return
+	}
+
+	// Let the browser reuse successful responses for the rest of their cache lifetime
+	if res.status == http.StatusOK {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(res.maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	// Copy response back to frontend (upstream errors are forwarded for debugging)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
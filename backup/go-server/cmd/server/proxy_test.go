@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testContract is a syntactically valid contract address for allowlisted endpoints.
+const testContract = "0x1111111111111111111111111111111111111111"
+
+// newTestProxy returns a proxy in front of a mock Moralis server running
+// upstream, with a temp cache dir and retries shortened so tests stay fast.
+func newTestProxy(t *testing.T, upstream http.HandlerFunc) *moralisProxy {
+	t.Helper()
+
+	mock := httptest.NewServer(upstream)
+	t.Cleanup(mock.Close)
+
+	backoff := upstreamRetryBackoff
+	upstreamRetryBackoff = []time.Duration{time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { upstreamRetryBackoff = backoff })
+
+	p := &moralisProxy{
+		keys:     &apiKeyPool{keys: []string{"test-key"}},
+		baseURL:  mock.URL,
+		cacheDir: t.TempDir(),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	// Runs before the temp dir is removed
+	t.Cleanup(p.background.Wait)
+	return p
+}
+
+// postProxy sends body to POST /api/proxy.
+func postProxy(p *moralisProxy, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/proxy", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	p.handleProxy(w, r)
+	return w
+}
+
+// getProxy sends GET /api/proxy with the given query string.
+func getProxy(p *moralisProxy, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/proxy?"+query, nil)
+	w := httptest.NewRecorder()
+	p.handleProxy(w, r)
+	return w
+}
+
+// setCacheTTL overrides the global cache TTL config for one test.
+func setCacheTTL(t *testing.T, ttl, staleMax time.Duration) {
+	t.Helper()
+	oldTTL, oldStale := cacheTTL, cacheStaleMax
+	cacheTTL, cacheStaleMax = ttl, staleMax
+	t.Cleanup(func() { cacheTTL, cacheStaleMax = oldTTL, oldStale })
+}
+
+// writeAgedCache stores body as the cache entry for req, last written age ago.
+func writeAgedCache(t *testing.T, p *moralisProxy, req proxyRequest, body string, age time.Duration) string {
+	t.Helper()
+	path := p.cachePath(req)
+	if err := writeCacheEntry(path, req, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// okUpstream answers every call with an empty Moralis result page.
+func okUpstream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"result":[]}`))
+}
+
+func TestAllowedEndpoints(t *testing.T) {
+	calls := 0
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		okUpstream(w, r)
+	})
+
+	for _, tc := range []struct {
+		endpoint string
+		want     int
+	}{
+		{"/nft/" + testContract, http.StatusOK},
+		{"/nft/" + testContract + "/transfers", http.StatusOK},
+		{"/nft/" + testContract + "/owners", http.StatusOK},
+		// Token metadata, used by the frontend's image fallback
+		{"/nft/" + testContract + "/42", http.StatusOK},
+		{"/nft/" + testContract + "/42/transfers", http.StatusOK},
+		{"/erc20/" + testContract + "/transfers", http.StatusForbidden},
+		{"/" + testContract + "/balance", http.StatusForbidden},
+		{"/nft/not-an-address", http.StatusForbidden},
+		{"/nft/" + testContract + "/../../erc20", http.StatusForbidden},
+	} {
+		calls = 0
+		w := postProxy(p, `{"endpoint":"`+tc.endpoint+`","params":{"chain":"eth","format":"decimal"}}`)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.endpoint, w.Code, tc.want)
+		}
+		if tc.want == http.StatusForbidden && calls != 0 {
+			t.Errorf("%s: rejected endpoint reached upstream", tc.endpoint)
+		}
+	}
+}
+
+func TestTargetURLEscapesParams(t *testing.T) {
+	var got string
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("cursor")
+		okUpstream(w, r)
+	})
+
+	cursor := "a&b=c+d e/f"
+	w := postProxy(p, `{"endpoint":"/nft/`+testContract+`/transfers","params":{"cursor":"`+cursor+`"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got != cursor {
+		t.Errorf("upstream cursor = %q, want %q", got, cursor)
+	}
+}
+
+func TestProxyErrorsAreJSON(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	single := `{"endpoint":"/nft/` + testContract + `"}`
+	for _, tc := range []struct {
+		name    string
+		method  string
+		body    string
+		baseURL string
+		want    int
+	}{
+		{"method not allowed", http.MethodPut, single, p.baseURL, http.StatusMethodNotAllowed},
+		{"invalid json", http.MethodPost, `{"endpoint":`, p.baseURL, http.StatusBadRequest},
+		{"request build", http.MethodPost, single, "http://bad host", http.StatusInternalServerError},
+		{"upstream unreachable", http.MethodPost, single, unreachable.URL, http.StatusBadGateway},
+	} {
+		p.baseURL = tc.baseURL
+		w := httptest.NewRecorder()
+		p.handleProxy(w, httptest.NewRequest(tc.method, "/api/proxy", strings.NewReader(tc.body)))
+
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q, want application/json", tc.name, ct)
+		}
+		var got struct {
+			Error string `json:"error"`
+			Code  int    `json:"code"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Error == "" || got.Code != tc.want {
+			t.Errorf("%s: body = %s, want {\"error\":...,\"code\":%d}", tc.name, w.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	setCacheTTL(t, time.Minute, time.Hour)
+
+	release := make(chan struct{})
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"result":["new"]}`))
+	})
+	req := proxyRequest{Endpoint: "/nft/" + testContract + "/owners"}
+	path := writeAgedCache(t, p, req, `{"result":["old"]}`, 2*time.Minute)
+
+	// Served from the stale entry while the upstream call is still blocked
+	w := postProxy(p, `{"endpoint":"`+req.Endpoint+`"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"result":["old"]}` {
+		t.Fatalf("got %d %s, want the stale body", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=0" {
+		t.Errorf("Cache-Control = %q, want max-age=0 for a stale entry", cc)
+	}
+
+	close(release)
+	p.background.Wait()
+
+	if got, err := readCacheBody(path); err != nil || string(got) != `{"result":["new"]}` {
+		t.Errorf("cache after refresh = %s, %v; want the new body", got, err)
+	}
+	if w := postProxy(p, `{"endpoint":"`+req.Endpoint+`"}`); w.Body.String() != `{"result":["new"]}` {
+		t.Errorf("next response = %s, want the refreshed body", w.Body.String())
+	}
+}
+
+func TestTargetURLDeterministic(t *testing.T) {
+	p := &moralisProxy{baseURL: "https://moralis.test/api/v2"}
+	keys := []string{"chain", "format", "limit", "cursor", "normalizeMetadata"}
+
+	var first, firstCache string
+	for i := 0; i < 20; i++ {
+		// Build the same params in a different insertion order each time
+		params := map[string]string{}
+		for j := range keys {
+			k := keys[(i+j)%len(keys)]
+			params[k] = "v-" + k
+		}
+		req := proxyRequest{Endpoint: "/nft/" + testContract, Params: params}
+		got, gotCache := p.targetURL(req), p.cachePath(req)
+		if i == 0 {
+			first, firstCache = got, gotCache
+			continue
+		}
+		if got != first {
+			t.Fatalf("targetURL differs for identical params:\n%s\n%s", got, first)
+		}
+		if gotCache != firstCache {
+			t.Fatalf("cachePath differs for identical params")
+		}
+	}
+	want := "https://moralis.test/api/v2/nft/" + testContract + "?chain=v-chain&cursor=v-cursor&format=v-format&limit=v-limit&normalizeMetadata=v-normalizeMetadata"
+	if first != want {
+		t.Errorf("targetURL = %s, want sorted params %s", first, want)
+	}
+}
+
+func TestUpstreamTimeoutReturns504(t *testing.T) {
+	var calls atomic.Int32
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	p.client.Timeout = 50 * time.Millisecond
+
+	w := postProxy(p, `{"endpoint":"/nft/`+testContract+`"}`)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if w.Body.String() != `{"code":504,"error":"moralis api timed out"}`+"\n" {
+		t.Errorf("body = %s", w.Body.String())
+	}
+	// Timeouts are not retried
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream calls = %d, want 1", n)
+	}
+}
+
+func TestGetMatchesPost(t *testing.T) {
+	var urls []string
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		urls = append(urls, r.URL.String())
+		okUpstream(w, r)
+	})
+	endpoint := "/nft/" + testContract + "/transfers"
+
+	post := postProxy(p, `{"endpoint":"`+endpoint+`","params":{"chain":"eth","limit":"100"}}`)
+	clearCache(p.cacheDir, "")
+	get := getProxy(p, "endpoint="+endpoint+"&p.limit=100&p.chain=eth&ignored=1")
+
+	if post.Code != http.StatusOK || get.Code != http.StatusOK {
+		t.Fatalf("status POST %d, GET %d, want 200", post.Code, get.Code)
+	}
+	if len(urls) != 2 || urls[0] != urls[1] {
+		t.Fatalf("upstream URLs = %q, want the same URL twice", urls)
+	}
+
+	// With the cache kept, the GET reuses the POST's cache file
+	getNoParams := getProxy(p, "endpoint="+endpoint)
+	postNoParams := postProxy(p, `{"endpoint":"`+endpoint+`"}`)
+	if getNoParams.Code != http.StatusOK || postNoParams.Code != http.StatusOK {
+		t.Fatalf("status GET %d, POST %d, want 200", getNoParams.Code, postNoParams.Code)
+	}
+	if len(urls) != 3 {
+		t.Errorf("upstream calls = %d, want 3 (POST served from the GET's cache file)", len(urls))
+	}
+	if n := cacheFiles(t, p.cacheDir); n != 2 {
+		t.Errorf("%d cache files, want 2", n)
+	}
+}
+
+func TestGetMissingEndpoint(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+	w := getProxy(p, "p.chain=eth")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestRetryOn5xx(t *testing.T) {
+	var calls atomic.Int32
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		okUpstream(w, r)
+	})
+
+	w := postProxy(p, `{"endpoint":"/nft/`+testContract+`"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 after retries", w.Code)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("upstream calls = %d, want 3", n)
+	}
+}
+
+func TestNon200NotCached(t *testing.T) {
+	var calls atomic.Int32
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"not found"}`))
+	})
+
+	for i := 0; i < 2; i++ {
+		w := postProxy(p, `{"endpoint":"/nft/`+testContract+`"}`)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want the upstream 404", w.Code)
+		}
+	}
+	// 4xx is neither retried nor cached
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d, want 2", n)
+	}
+	if n := cacheFiles(t, p.cacheDir); n != 0 {
+		t.Errorf("%d cache files, want 0", n)
+	}
+}
+
+func TestIsRetryableUpstream(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{"ok", http.StatusOK, nil, false},
+		{"not found", http.StatusNotFound, nil, false},
+		{"bad gateway", http.StatusBadGateway, nil, true},
+		{"connection refused", 0, &url.Error{Op: "Get", Err: errors.New("connection refused")}, true},
+		{"truncated body", 0, fmt.Errorf("read response: %w", io.ErrUnexpectedEOF), true},
+		{"timeout", 0, &url.Error{Op: "Get", Err: context.DeadlineExceeded}, false},
+		{"request build", 0, fmt.Errorf("%w: bad url", errBuildRequest), false},
+		{"keys rate limited", 0, errKeysRateLimited, false},
+	} {
+		if got := isRetryableUpstream(tc.status, tc.err); got != tc.want {
+			t.Errorf("%s: isRetryableUpstream = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRetryBackoffStopsOnCancel(t *testing.T) {
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	upstreamRetryBackoff = []time.Duration{time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := p.fetch(ctx, proxyRequest{Endpoint: "/nft/" + testContract}, requestLogger{id: "test"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetch() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch() took %s after the context ended", elapsed)
+	}
+}
+
+func TestMoralisErrorEnvelopeNotCached(t *testing.T) {
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message":"Invalid address"}`))
+	})
+
+	w := postProxy(p, `{"endpoint":"/nft/`+testContract+`"}`)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", w.Code)
+	}
+	if n := cacheFiles(t, p.cacheDir); n != 0 {
+		t.Errorf("%d cache files, want 0", n)
+	}
+}
+
+func TestIsMoralisErrorEnvelope(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"message":"Invalid address"}`:     true,
+		`{"result":[],"message":"partial"}`: false,
+		`{"result":[]}`:                     false,
+		`[{"message":"x"}]`:                 false,
+		`not json`:                          false,
+	} {
+		if got := isMoralisErrorEnvelope([]byte(body)); got != want {
+			t.Errorf("isMoralisErrorEnvelope(%s) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestCacheControlMaxAge(t *testing.T) {
+	setCacheTTL(t, 24*time.Hour, 0)
+	p := newTestProxy(t, okUpstream)
+	req := proxyRequest{Endpoint: "/nft/" + testContract}
+
+	w := postProxy(p, `{"endpoint":"`+req.Endpoint+`"}`)
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+		t.Errorf("fresh fetch Cache-Control = %q, want the full TTL", cc)
+	}
+
+	// A cache hit on an entry written an hour ago has an hour less to live
+	writeAgedCache(t, p, req, `{"result":[]}`, time.Hour)
+	w = postProxy(p, `{"endpoint":"`+req.Endpoint+`"}`)
+	var maxAge int
+	if _, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge); err != nil {
+		t.Fatalf("Cache-Control = %q: %v", w.Header().Get("Cache-Control"), err)
+	}
+	if maxAge > 23*3600 || maxAge < 23*3600-60 {
+		t.Errorf("cache hit max-age = %d, want about %d", maxAge, 23*3600)
+	}
+}
+
+func TestCacheControlNoStoreOnErrors(t *testing.T) {
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"upstream error": postProxy(p, `{"endpoint":"/nft/`+testContract+`"}`),
+		"rejected":       postProxy(p, `{"endpoint":"/erc20/x"}`),
+		"invalid json":   postProxy(p, `{`),
+	} {
+		if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control = %q, want no-store", name, cc)
+		}
+	}
+}
+
+func TestHeadUptimeCheck(t *testing.T) {
+	var calls atomic.Int32
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		okUpstream(w, r)
+	})
+
+	for _, tc := range []struct {
+		keys []string
+		want int
+	}{
+		{[]string{"test-key"}, http.StatusOK},
+		{nil, http.StatusServiceUnavailable},
+	} {
+		p.keys = &apiKeyPool{keys: tc.keys}
+		w := httptest.NewRecorder()
+		p.handleProxy(w, httptest.NewRequest(http.MethodHead, "/api/proxy", nil))
+
+		if w.Code != tc.want {
+			t.Errorf("keys %q: status = %d, want %d", tc.keys, w.Code, tc.want)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("keys %q: body = %q, want empty", tc.keys, w.Body.String())
+		}
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("HEAD made %d upstream calls, want 0", n)
+	}
+}
+
+func TestConcurrentIdenticalRequestsShareOneCall(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		okUpstream(w, r)
+	})
+
+	const n = 10
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			codes <- postProxy(p, `{"endpoint":"/nft/`+testContract+`"}`).Code
+		}()
+	}
+	<-started
+	// Give the other callers time to join the in-flight call
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < n; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("status = %d, want 200", code)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("upstream calls = %d, want 1", got)
+	}
+}
+
+func TestCancelledCallerDoesNotFailSharedCall(t *testing.T) {
+	release := make(chan struct{})
+	p := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		okUpstream(w, r)
+	})
+	req := proxyRequest{Endpoint: "/nft/" + testContract}
+	logger := requestLogger{id: "test"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := p.resolve(ctx, req, "", logger)
+		first <- err
+	}()
+	second := make(chan proxyResult, 1)
+	go func() {
+		res, _ := p.resolve(context.Background(), req, "", logger)
+		second <- res
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if res := <-second; res.status != http.StatusOK {
+		t.Errorf("waiting caller status = %d, want 200", res.status)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProxyRPS         = 10.0
+	defaultTrustedProxyHops = 1
+	limiterIdleTimeout      = 10 * time.Minute
+	limiterGCInterval       = time.Minute
+)
+
+// trustedProxyHops is how many X-Forwarded-For entries, counted from the
+// right, were appended by our own load balancers. Configured by
+// TRUSTED_PROXY_HOPS; Cloud Run's front end adds one.
+var trustedProxyHops = defaultTrustedProxyHops
+
+// tokenBucket holds the state for a single key.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// keyedRateLimiter keeps one token bucket per key. It limits /api/proxy per
+// client IP, so a single caller can't burn through the Moralis quota, and
+// each Moralis API key (see apiKeyPool).
+type keyedRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newKeyedRateLimiter(rps float64) *keyedRateLimiter {
+	return &keyedRateLimiter{
+		rate:    rps,
+		burst:   math.Max(1, math.Ceil(rps*2)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// loadProxyRateLimiter reads PROXY_RPS (requests per second per client IP)
+// and TRUSTED_PROXY_HOPS (see clientIP).
+// Returns nil (no limiting) when PROXY_RPS is 0 or negative.
+func loadProxyRateLimiter() *keyedRateLimiter {
+	if raw := os.Getenv("TRUSTED_PROXY_HOPS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			warnf("Warning: Invalid TRUSTED_PROXY_HOPS %q, using default %d", raw, defaultTrustedProxyHops)
+		} else {
+			trustedProxyHops = n
+		}
+	}
+
+	rps := defaultProxyRPS
+	if raw := os.Getenv("PROXY_RPS"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			warnf("Warning: Invalid PROXY_RPS %q, using default %.0f", raw, defaultProxyRPS)
+		} else {
+			rps = v
+		}
+	}
+	if rps <= 0 {
+		infof("Proxy rate limiting disabled")
+		return nil
+	}
+	infof("Proxy rate limit: %.2f req/s per client", rps)
+
+	limiter := newKeyedRateLimiter(rps)
+	go func() {
+		// Garbage-collect idle clients so the map doesn't grow without bound
+		for range time.Tick(limiterGCInterval) {
+			limiter.cleanup(limiterIdleTimeout)
+		}
+	}()
+	return limiter
+}
+
+// allow consumes a token for key. When the bucket is empty it returns false
+// and how long the caller should wait before retrying.
+func (l *keyedRateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill based on elapsed time
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup removes buckets that have not been used for maxIdle.
+func (l *keyedRateLimiter) cleanup(maxIdle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if time.Since(b.last) > maxIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the caller's IP. Clients can put anything in
+// X-Forwarded-For, so only the entry appended by the outermost trusted proxy
+// (trustedProxyHops from the right) is used, falling back to RemoteAddr.
+func clientIP(r *http.Request) string {
+	if trustedProxyHops > 0 {
+		var hops []string
+		for _, xff := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(xff, ",")...)
+		}
+		if i := len(hops) - trustedProxyHops; i >= 0 {
+			if ip := strings.TrimSpace(hops[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
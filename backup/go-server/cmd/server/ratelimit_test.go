@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitReturns429(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+	p.limiter = newKeyedRateLimiter(1) // burst of 2
+
+	query := "endpoint=/nft/" + testContract
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/api/proxy?"+query, nil)
+		// A client rotating the spoofable part of the header must not get a fresh bucket
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d, 203.0.113.7", i+1))
+		w := httptest.NewRecorder()
+		p.handleProxy(w, r)
+
+		if w.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	old := trustedProxyHops
+	t.Cleanup(func() { trustedProxyHops = old })
+
+	for _, tc := range []struct {
+		hops int
+		xff  []string
+		want string
+	}{
+		{1, nil, "192.0.2.1"},
+		{1, []string{"203.0.113.7"}, "203.0.113.7"},
+		{1, []string{"10.0.0.1, 203.0.113.7"}, "203.0.113.7"},
+		{1, []string{"10.0.0.1", "203.0.113.7"}, "203.0.113.7"},
+		{2, []string{"10.0.0.1, 203.0.113.7, 198.51.100.2"}, "203.0.113.7"},
+		{2, []string{"203.0.113.7"}, "192.0.2.1"},
+		{0, []string{"203.0.113.7"}, "192.0.2.1"},
+	} {
+		trustedProxyHops = tc.hops
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r); got != tc.want {
+			t.Errorf("hops %d, X-Forwarded-For %q: clientIP = %q, want %q", tc.hops, tc.xff, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// requestID returns the caller's X-Request-ID when it looks sane,
+// otherwise a new random UUID (v4).
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); isValidRequestID(id) {
+		return id
+	}
+	return newUUID()
+}
+
+// isValidRequestID rejects empty, oversized or non-printable IDs so a client
+// can't inject arbitrary text into the logs.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestLogger prefixes every log line with the request ID so frontend
+// errors can be correlated with server logs.
+type requestLogger struct {
+	id string
+}
+
+func (l requestLogger) logf(level logLevel, format string, args ...interface{}) {
+	logf(level, "[%s] "+format, append([]interface{}{l.id}, args...)...)
+}
+
+func (l requestLogger) Debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+func (l requestLogger) Infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+func (l requestLogger) Warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+func (l requestLogger) Errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestIDEchoedAndLogged(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+	logs := captureLogs(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/proxy", strings.NewReader(`{"endpoint":"/erc20/x"}`))
+	r.Header.Set(requestIDHeader, "frontend-42")
+	w := httptest.NewRecorder()
+	p.handleProxy(w, r)
+
+	if got := w.Header().Get(requestIDHeader); got != "frontend-42" {
+		t.Errorf("%s = %q, want the caller's ID", requestIDHeader, got)
+	}
+	if !strings.Contains(logs.String(), "[frontend-42] Rejected endpoint: /erc20/x") {
+		t.Errorf("logs don't carry the request ID:\n%s", logs.String())
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	p := newTestProxy(t, okUpstream)
+
+	for _, sent := range []string{"", "has space", strings.Repeat("x", maxRequestIDLength+1)} {
+		r := httptest.NewRequest(http.MethodHead, "/api/proxy", nil)
+		if sent != "" {
+			r.Header.Set(requestIDHeader, sent)
+		}
+		w := httptest.NewRecorder()
+		p.handleProxy(w, r)
+
+		if got := w.Header().Get(requestIDHeader); !uuidPattern.MatchString(got) {
+			t.Errorf("sent %q: %s = %q, want a generated UUID", sent, requestIDHeader, got)
+		}
+	}
+}
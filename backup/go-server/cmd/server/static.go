@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+const defaultStaticDir = "static"
+
+// fallbackIndexHTML is served at "/" when the static directory is missing,
+// so a misconfigured deploy is obvious instead of a bare 404.
+const fallbackIndexHTML = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Covered People NFT Network Visualizer</title></head>
+<body>
+<h1>Covered People NFT Network Visualizer</h1>
+<p>The frontend files were not found on this server. Set STATIC_DIR to the directory containing index.html.</p>
+<p>API: <code>/api/proxy</code>, health: <code>/healthz</code></p>
+</body>
+</html>
+`
+
+// staticHandler serves files from dir, or the built-in index page if dir doesn't exist.
+func staticHandler(dir string) http.Handler {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		warnf("Warning: static directory %q not found, serving built-in index page", dir)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(fallbackIndexHTML))
+		})
+	}
+	infof("Serving static files from %s", dir)
+	return http.FileServer(http.Dir(dir))
+}
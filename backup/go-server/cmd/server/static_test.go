@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func serveStatic(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestStaticHandlerServesDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>custom index</p>"), 0644)
+	os.WriteFile(filepath.Join(dir, "script.js"), []byte("console.log(1)"), 0644)
+
+	h := staticHandler(dir)
+	if w := serveStatic(h, "/"); w.Code != http.StatusOK || w.Body.String() != "<p>custom index</p>" {
+		t.Errorf("/ = %d %q, want the directory's index.html", w.Code, w.Body.String())
+	}
+	if w := serveStatic(h, "/script.js"); w.Code != http.StatusOK || w.Body.String() != "console.log(1)" {
+		t.Errorf("/script.js = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestStaticHandlerFallback(t *testing.T) {
+	h := staticHandler(filepath.Join(t.TempDir(), "missing"))
+
+	w := serveStatic(h, "/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Set STATIC_DIR") {
+		t.Errorf("/ = %d %q, want the built-in index page", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if w := serveStatic(h, "/script.js"); w.Code != http.StatusNotFound {
+		t.Errorf("/script.js = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Build metadata, injected at build time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.gitCommit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+var (
+	version   = "dev"
+	gitCommit = "dev"
+	buildTime = "dev"
+)
+
+// handleVersion is the /version endpoint, used to confirm which build is live.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"git_commit": gitCommit,
+		"build_time": buildTime,
+	})
+}
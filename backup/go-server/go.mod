@@ -1,3 +1,5 @@
 module github.com/treetree/covered-people-visualizer
 
 go 1.24.6
+
+require golang.org/x/sync v0.19.0